@@ -0,0 +1,126 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package config defines the CRI plugin's configuration, as loaded from the
+// `[plugins."io.containerd.grpc.v1.cri"]` section of containerd's config.toml.
+package config
+
+const (
+	// KeyModelNode is the decryption key model where keys are held by the
+	// node and the node is trusted to decrypt content.
+	KeyModelNode = "node"
+)
+
+// Runtime is a runtime configured under ContainerdConfig.Runtimes.
+type Runtime struct {
+	// Snapshotter is the snapshotter to use for containers created with
+	// this runtime, overriding ContainerdConfig.Snapshotter.
+	Snapshotter string `toml:"snapshotter" json:"snapshotter"`
+}
+
+// ContainerdConfig holds the configuration for the containerd runtime
+// plugin used by CRI.
+type ContainerdConfig struct {
+	// Snapshotter is the default snapshotter used when pulling and
+	// unpacking images.
+	Snapshotter string `toml:"snapshotter" json:"snapshotter"`
+	// Runtimes is a map from a runtime handler name to its configuration.
+	Runtimes map[string]Runtime `toml:"runtimes" json:"runtimes"`
+}
+
+// AuthConfig is registry credentials, or the helper that knows how to
+// produce them. Under Registry.Auths (keyed by host or "*") only Helper is
+// consulted; under Registry.Configs[host].Auth every field is.
+type AuthConfig struct {
+	// Username, Password, Auth, and IdentityToken mirror
+	// runtime.AuthConfig and are used as-is when set under Registry.Configs.
+	Username      string `toml:"username" json:"username"`
+	Password      string `toml:"password" json:"password"`
+	Auth          string `toml:"auth" json:"auth"`
+	IdentityToken string `toml:"identitytoken" json:"identitytoken"`
+	// Helper is a docker-credential-helpers binary (bare name, resolved as
+	// docker-credential-<helper> via $PATH, or a full path) to exec for
+	// this host's credentials.
+	Helper string `toml:"helper" json:"helper"`
+}
+
+// TLSConfig is the client TLS configuration to use when talking to a
+// specific registry host.
+type TLSConfig struct {
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify" json:"insecure_skip_verify"`
+	CAFile             string `toml:"ca_file" json:"ca_file"`
+	CertFile           string `toml:"cert_file" json:"cert_file"`
+	KeyFile            string `toml:"key_file" json:"key_file"`
+}
+
+// RegistryConfig is the per-host configuration nested under
+// Registry.Configs.
+type RegistryConfig struct {
+	Auth *AuthConfig `toml:"auth" json:"auth"`
+	TLS  *TLSConfig  `toml:"tls" json:"tls"`
+}
+
+// Mirror is the endpoints to try, in order, for pulls from a given host.
+type Mirror struct {
+	Endpoints []string `toml:"endpoint" json:"endpoint"`
+}
+
+// Registry is the `registry` section of the CRI plugin config.
+type Registry struct {
+	// Mirrors maps a registry host (or "*") to the mirror endpoints to
+	// consult before the host's own default endpoint.
+	Mirrors map[string]Mirror `toml:"mirrors" json:"mirrors"`
+	// Configs maps a registry host to auth/TLS settings for that host.
+	Configs map[string]RegistryConfig `toml:"configs" json:"configs"`
+	// Auths maps a registry host (or "*") to a credential-helper
+	// declaration, consulted when kubelet sends no AuthConfig.
+	Auths map[string]AuthConfig `toml:"auths" json:"auths"`
+	// ConfigFile points at a Docker/Podman-style auth.json used as a
+	// fallback credential source, below Auths and above anonymous.
+	ConfigFile string `toml:"config_file" json:"configFile"`
+	// AuthSoftFail, when true, makes a pull that finds no matching
+	// credential in Auths/ConfigFile proceed anonymously instead of
+	// failing. Overridable per pod via the
+	// "containerd.io/auth-soft-fail" annotation.
+	AuthSoftFail bool `toml:"auth_soft_fail" json:"authSoftFail"`
+	// CredentialCacheTTL is how long a credential helper lookup in Auths is
+	// cached before the helper is exec'd again, as a Go duration string
+	// (e.g. "5m"). Defaults to 5 minutes when unset or invalid.
+	CredentialCacheTTL string `toml:"credential_cache_ttl" json:"credentialCacheTTL"`
+}
+
+// ImageDecryption configures decryption of encrypted container images.
+type ImageDecryption struct {
+	// KeyModel defines the trust model used for decryption keys, e.g.
+	// KeyModelNode.
+	KeyModel string `toml:"key_model" json:"keyModel"`
+}
+
+// PluginConfig is the `[plugins."io.containerd.grpc.v1.cri"]` config,
+// embedded in Config.
+type PluginConfig struct {
+	ContainerdConfig ContainerdConfig `toml:"containerd" json:"containerd"`
+	Registry         Registry         `toml:"registry" json:"registry"`
+	ImageDecryption  ImageDecryption  `toml:"image_decryption" json:"imageDecryption"`
+	// SandboxImage is the reference of the sandbox (pause) image pinned on
+	// this node.
+	SandboxImage string `toml:"sandbox_image" json:"sandboxImage"`
+}
+
+// Config is the CRI plugin's runtime configuration.
+type Config struct {
+	PluginConfig
+}
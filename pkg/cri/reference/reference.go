@@ -0,0 +1,116 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package reference canonicalizes container image references so that two
+// references which differ only by an implied registry domain, an implied
+// "library/" prefix, or an implied ":latest" tag can be compared for
+// equality, mirroring the decomposition moby's registry.ParseRepositoryInfo
+// historically performed.
+package reference
+
+import (
+	"strings"
+
+	distref "github.com/distribution/reference"
+)
+
+const (
+	// DefaultDomain is the domain implied by a reference with no explicit
+	// registry host.
+	DefaultDomain = "docker.io"
+	// LegacyDefaultDomain is accepted as an alias of DefaultDomain, for
+	// references written out by older Docker clients.
+	LegacyDefaultDomain = "index.docker.io"
+	// officialRepoPrefix is implied for single-segment repository names
+	// under DefaultDomain, e.g. "pause" canonicalizes to "library/pause".
+	officialRepoPrefix = "library/"
+)
+
+// RepositoryInfo is a decomposed, canonicalized image reference.
+type RepositoryInfo struct {
+	// Index is the registry domain, with DefaultDomain substituted for
+	// LegacyDefaultDomain.
+	Index string
+	// RemoteName is the repository path with the implied officialRepoPrefix
+	// stripped.
+	RemoteName string
+	// Tag is the reference's tag. A reference with neither a tag nor a
+	// digest gets the implied "latest"; a reference with a digest has Tag
+	// "", since a tag given alongside a digest is dropped.
+	Tag string
+	// Digest is the reference's digest, or "" if it has none.
+	Digest string
+	// Official reports whether RemoteName is an official, single-segment
+	// repository under DefaultDomain (e.g. "docker.io/library/pause").
+	Official bool
+}
+
+// Parse decomposes ref into a RepositoryInfo. ref may carry a tag, a digest,
+// both, or neither.
+func Parse(ref string) (RepositoryInfo, error) {
+	named, err := distref.ParseDockerRef(ref)
+	if err != nil {
+		return RepositoryInfo{}, err
+	}
+
+	index := distref.Domain(named)
+	if index == LegacyDefaultDomain {
+		index = DefaultDomain
+	}
+
+	remoteName := distref.Path(named)
+	if index == DefaultDomain {
+		remoteName = strings.TrimPrefix(remoteName, officialRepoPrefix)
+	}
+
+	info := RepositoryInfo{
+		Index:      index,
+		RemoteName: remoteName,
+		Official:   index == DefaultDomain && !strings.Contains(remoteName, "/"),
+	}
+	if tagged, ok := named.(distref.Tagged); ok {
+		info.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(distref.Digested); ok {
+		info.Digest = digested.Digest().String()
+	}
+
+	return info, nil
+}
+
+// key returns the canonical index/repository pair used to compare two
+// RepositoryInfo values, ignoring tag and digest.
+func (r RepositoryInfo) key() string {
+	return r.Index + "/" + r.RemoteName
+}
+
+// Matches reports whether r and other refer to the same image. A reference
+// that pins only a tag or only a digest is treated as compatible with one
+// that pins the other, or neither, as long as whichever of the two is
+// present on both sides agrees. Values from Parse always carry a tag or a
+// digest, so e.g. "pause" (implied "latest") does not match "pause:3.9".
+func (r RepositoryInfo) Matches(other RepositoryInfo) bool {
+	if r.key() != other.key() {
+		return false
+	}
+	if r.Digest != "" && other.Digest != "" {
+		return r.Digest == other.Digest
+	}
+	if r.Tag != "" && other.Tag != "" {
+		return r.Tag == other.Tag
+	}
+	return true
+}
@@ -0,0 +1,752 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	containerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/pkg/cri/annotations"
+	criconfig "github.com/containerd/containerd/pkg/cri/config"
+	"github.com/containerd/containerd/pkg/cri/labels"
+	criref "github.com/containerd/containerd/pkg/cri/reference"
+	imagestore "github.com/containerd/containerd/pkg/cri/store/image"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+	distref "github.com/distribution/reference"
+
+	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// PullImage pulls an image with authentication config.
+func (c *criService) PullImage(ctx context.Context, r *runtime.PullImageRequest) (*runtime.PullImageResponse, error) {
+	imageRef := r.GetImage().GetImage()
+
+	snapshotter, err := c.snapshotterFromPodSandboxConfig(ctx, imageRef, r.GetSandboxConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{
+		// registryHosts feeds the same credential resolution
+		// (credHelpers, authFile, kubelet AuthConfig, auth-soft-fail) this
+		// series added into the actual fetch, and reuses
+		// c.registryEndpoints so mirrors and per-host TLS config
+		// (Registry.Configs) apply to the real pull, not just to tests.
+		Hosts: c.registryHosts(ctx, r.GetAuth(), r.GetSandboxConfig()),
+	})
+
+	pullOpts := append([]containerd.RemoteOpt{
+		containerd.WithResolver(resolver),
+		containerd.WithPullSnapshotter(snapshotter),
+		containerd.WithPullUnpack,
+		containerd.WithPullLabels(c.getLabels(ctx, imageRef)),
+	}, c.encryptedImagesPullOpts()...)
+
+	img, err := c.client.Pull(ctx, imageRef, pullOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull and unpack image %q: %w", imageRef, err)
+	}
+
+	return &runtime.PullImageResponse{ImageRef: img.Name()}, nil
+}
+
+// authSoftFailAnnotation lets a pod opt in or out of Registry.AuthSoftFail
+// on a per-image basis via its PodSandboxConfig annotations.
+const authSoftFailAnnotation = "containerd.io/auth-soft-fail"
+
+// credentialsForImage resolves the username/secret to use when talking to
+// the registry host of ref. It's a thin wrapper around credentialsForHost
+// for callers that only have a reference to hand rather than an
+// already-parsed host.
+func (c *criService) credentialsForImage(ctx context.Context, ref string, auth *runtime.AuthConfig, sandbox *runtime.PodSandboxConfig) (string, string, error) {
+	host, err := hostFromImageRef(ref)
+	if err != nil {
+		return "", "", err
+	}
+	return c.credentialsForHost(ctx, host, auth, sandbox)
+}
+
+// credentialsForHost resolves the username/secret to use for host. It first
+// honors the AuthConfig kubelet sent on the request, then falls back to
+// configured registry credential helpers and the static auth file. If a
+// credential source is configured but none of them have an entry for host,
+// the pull fails unless auth-soft-fail is enabled, in which case it
+// proceeds anonymously.
+func (c *criService) credentialsForHost(ctx context.Context, host string, auth *runtime.AuthConfig, sandbox *runtime.PodSandboxConfig) (string, string, error) {
+	if auth != nil && (auth.Username != "" || auth.Password != "" || auth.Auth != "" || auth.IdentityToken != "") {
+		return ParseAuth(auth, host)
+	}
+
+	if cred, ok, err := c.credHelpers.resolve(ctx, host); err != nil {
+		return "", "", err
+	} else if ok {
+		return cred.Username, cred.Secret, nil
+	}
+
+	if cred, ok, err := c.authFile.resolve(host); err != nil {
+		return "", "", err
+	} else if ok {
+		return cred.Username, cred.Secret, nil
+	}
+
+	if cred, ok, err := c.configAuth(ctx, host); err != nil {
+		return "", "", err
+	} else if ok {
+		return cred.Username, cred.Secret, nil
+	}
+
+	if c.hasCredentialSource() {
+		if !c.authSoftFail(sandbox) {
+			return "", "", fmt.Errorf("no registry credentials found for %q and auth_soft_fail is disabled", host)
+		}
+		log.G(ctx).Debugf("no registry credentials found for %q, proceeding with anonymous pull", host)
+	}
+
+	return ParseAuth(auth, host)
+}
+
+// registryHosts returns the docker.RegistryHosts callback used to resolve
+// and fetch from a registry, applying mirror endpoints
+// (c.registryEndpoints), per-host TLS config (Registry.Configs), and the
+// credential resolved by credentialsForHost. PullImage and the referrers
+// lookup in image_referrers.go both go through this so they see the same
+// mirrors, TLS trust, and node-configured credentials as each other; the
+// referrers lookup passes no kubelet AuthConfig or pod sandbox config.
+func (c *criService) registryHosts(ctx context.Context, auth *runtime.AuthConfig, sandbox *runtime.PodSandboxConfig) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		endpoints, err := c.registryEndpoints(host)
+		if err != nil {
+			return nil, fmt.Errorf("get registry endpoints for %q: %w", host, err)
+		}
+
+		authorizer := docker.NewDockerAuthorizer(
+			docker.WithAuthClient(c.httpClient(host)),
+			docker.WithAuthCreds(func(resolveHost string) (string, string, error) {
+				return c.credentialsForHost(ctx, resolveHost, auth, sandbox)
+			}),
+		)
+
+		hosts := make([]docker.RegistryHost, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("parse registry endpoint %q: %w", endpoint, err)
+			}
+			hosts = append(hosts, docker.RegistryHost{
+				Client:       c.httpClient(u.Host),
+				Authorizer:   authorizer,
+				Host:         u.Host,
+				Scheme:       u.Scheme,
+				Path:         "/v2",
+				Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve,
+			})
+		}
+		return hosts, nil
+	}
+}
+
+// httpClient returns the *http.Client to use for host, applying the TLS
+// config from Registry.Configs[host].TLS when the operator has set one.
+func (c *criService) httpClient(host string) *http.Client {
+	tlsConfig, err := c.registryTLSConfig(host)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to build TLS config for registry %q, using defaults", host)
+		tlsConfig = nil
+	}
+	if tlsConfig == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+}
+
+// registryTLSConfig builds the tls.Config for host from Registry.Configs,
+// returning nil (use the Go defaults) when host has no TLS config set.
+func (c *criService) registryTLSConfig(host string) (*tls.Config, error) {
+	cfg, ok := c.config.Registry.Configs[host]
+	if !ok || cfg.TLS == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.TLS.InsecureSkipVerify, //nolint:gosec // operator opt-in via Registry.Configs
+	}
+
+	if cfg.TLS.CAFile != "" {
+		pool, err := loadCertPool(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load CA file %s: %w", cfg.TLS.CAFile, err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert/key for %q: %w", host, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// configAuth resolves the credential configured for host under
+// Registry.Configs[host].Auth, the mirror-scoped auth entry that predates
+// Registry.Auths and Registry.ConfigFile. ok is false when host has no
+// Configs entry or its Auth is unset, in which case callers should fall
+// through to the next credential source.
+func (c *criService) configAuth(ctx context.Context, host string) (registryCredential, bool, error) {
+	cfg, ok := c.config.Registry.Configs[host]
+	if !ok || cfg.Auth == nil {
+		return registryCredential{}, false, nil
+	}
+	return credentialFromConfigAuth(ctx, *cfg.Auth, host)
+}
+
+// credentialFromConfigAuth resolves a criconfig.AuthConfig the same way
+// ParseAuth resolves a kubelet-supplied runtime.AuthConfig, plus support for
+// auth.Helper since Registry.Configs entries can name a credential helper
+// too. ok is false when auth has none of Helper/IdentityToken/Username/Auth
+// set.
+func credentialFromConfigAuth(ctx context.Context, auth criconfig.AuthConfig, host string) (registryCredential, bool, error) {
+	if auth.Helper != "" {
+		cred, err := execCredentialHelper(ctx, auth.Helper, host)
+		if err != nil {
+			return registryCredential{}, false, err
+		}
+		return cred, true, nil
+	}
+	if auth.IdentityToken != "" {
+		return registryCredential{Secret: auth.IdentityToken}, true, nil
+	}
+	if auth.Username != "" {
+		return registryCredential{Username: auth.Username, Secret: auth.Password}, true, nil
+	}
+	if auth.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return registryCredential{}, false, fmt.Errorf("decode auth for %q: %w", host, err)
+		}
+		fields := strings.SplitN(string(decoded), ":", 2)
+		if len(fields) != 2 {
+			return registryCredential{}, false, fmt.Errorf("invalid auth for %q", host)
+		}
+		return registryCredential{Username: fields[0], Secret: fields[1]}, true, nil
+	}
+	return registryCredential{}, false, nil
+}
+
+// hasCredentialSource reports whether any registry credential source
+// (credential helpers, a static auth file, or a mirror-scoped
+// Registry.Configs auth entry) is configured.
+func (c *criService) hasCredentialSource() bool {
+	if c.credHelpers != nil && len(c.credHelpers.entries) > 0 {
+		return true
+	}
+	if c.authFile != nil && c.authFile.path != "" {
+		return true
+	}
+	for _, cfg := range c.config.Registry.Configs {
+		if cfg.Auth != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// authSoftFail reports whether an unmatched credential lookup should fall
+// back to an anonymous pull rather than failing, honoring a per-pod
+// annotation override of the global Registry.AuthSoftFail setting.
+func (c *criService) authSoftFail(sandbox *runtime.PodSandboxConfig) bool {
+	if sandbox != nil {
+		if v, ok := sandbox.GetAnnotations()[authSoftFailAnnotation]; ok {
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+	}
+	return c.config.Registry.AuthSoftFail
+}
+
+func hostFromImageRef(ref string) (string, error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %q: %w", ref, err)
+	}
+	return distref.Domain(named), nil
+}
+
+// registryCredential is a resolved {Username, Secret} pair, mirroring the
+// shape ParseAuth already returns for kubelet-supplied AuthConfig.
+type registryCredential struct {
+	Username string
+	Secret   string
+}
+
+// credentialHelperSource execs the configured `docker-credential-<helper>`
+// binary for a registry host and caches the result for Registry.Auths'
+// configured TTL, so that a hot pull path doesn't shell out on every pull.
+type credentialHelperSource struct {
+	entries map[string]criconfig.AuthConfig
+	ttl     time.Duration
+	now     func() time.Time
+
+	cacheMu sync.Mutex
+	cache   map[string]credHelperCacheEntry
+}
+
+type credHelperCacheEntry struct {
+	cred    registryCredential
+	expires time.Time
+}
+
+// dockerCredentialHelperOutput is the JSON payload a `docker-credential-*
+// get` helper writes to stdout, per the docker-credential-helpers protocol.
+type dockerCredentialHelperOutput struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+func newCredentialHelperSource(auths map[string]criconfig.AuthConfig, ttl time.Duration) *credentialHelperSource {
+	return &credentialHelperSource{
+		entries: auths,
+		ttl:     ttl,
+		now:     time.Now,
+		cache:   make(map[string]credHelperCacheEntry),
+	}
+}
+
+// resolve looks up the helper configured for host (falling back to the "*"
+// wildcard entry), execs it, and returns the credential it reports. ok is
+// false when no helper is configured for host, in which case callers should
+// fall through to the next credential source.
+func (s *credentialHelperSource) resolve(ctx context.Context, host string) (registryCredential, bool, error) {
+	if s == nil || len(s.entries) == 0 {
+		return registryCredential{}, false, nil
+	}
+
+	auth, ok := s.entries[host]
+	if !ok {
+		auth, ok = s.entries["*"]
+	}
+	if !ok || auth.Helper == "" {
+		return registryCredential{}, false, nil
+	}
+
+	if cred, ok := s.cached(host); ok {
+		return cred, true, nil
+	}
+
+	cred, err := execCredentialHelper(ctx, auth.Helper, host)
+	if err != nil {
+		return registryCredential{}, false, err
+	}
+
+	s.store(host, cred)
+	return cred, true, nil
+}
+
+func (s *credentialHelperSource) cached(host string) (registryCredential, bool) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	entry, ok := s.cache[host]
+	if !ok || s.now().After(entry.expires) {
+		return registryCredential{}, false
+	}
+	return entry.cred, true
+}
+
+func (s *credentialHelperSource) store(host string, cred registryCredential) {
+	if s.ttl <= 0 {
+		return
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cache[host] = credHelperCacheEntry{cred: cred, expires: s.now().Add(s.ttl)}
+}
+
+// execCredentialHelper runs `docker-credential-<helper> get`, feeding host on
+// stdin and parsing the {ServerURL, Username, Secret} JSON it writes to
+// stdout. helper may be a bare name (resolved via $PATH as
+// docker-credential-<helper>) or a full path to the binary.
+func execCredentialHelper(ctx context.Context, helper, host string) (registryCredential, error) {
+	bin := helper
+	if !strings.ContainsAny(helper, "/\\") {
+		bin = "docker-credential-" + helper
+	}
+
+	cmd := exec.CommandContext(ctx, bin, "get")
+	cmd.Stdin = strings.NewReader(host)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return registryCredential{}, fmt.Errorf("credential helper %q failed for %q: %w: %s", helper, host, err, msg)
+		}
+		return registryCredential{}, fmt.Errorf("credential helper %q failed for %q: %w", helper, host, err)
+	}
+
+	var out dockerCredentialHelperOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return registryCredential{}, fmt.Errorf("failed to parse credential helper %q output for %q: %w", helper, host, err)
+	}
+
+	// The helper protocol overloads Secret to carry an identity token when
+	// Username is the sentinel "<token>", matching ParseAuth's handling of
+	// runtime.AuthConfig.IdentityToken below.
+	if out.Username == "<token>" {
+		return registryCredential{Secret: out.Secret}, nil
+	}
+	return registryCredential{Username: out.Username, Secret: out.Secret}, nil
+}
+
+// authFileEntry is one value of the "auths" map in a Docker/Podman-style
+// auth.json config file.
+type authFileEntry struct {
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// authFileSource reads registry credentials from Registry.ConfigFile,
+// reloading it whenever its mtime changes so operators can rotate the
+// mounted secret without restarting containerd.
+type authFileSource struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	auths   map[string]authFileEntry
+}
+
+func newAuthFileSource(path string) *authFileSource {
+	return &authFileSource{path: path}
+}
+
+// resolve looks up host in the auth file: an entry keyed by exactly host
+// wins, and otherwise a key written as a URL matches on its host, the way
+// ParseAuth matches AuthConfig.ServerAddress. ok is false when no config
+// file is set or host has no entry, in which case callers should fall
+// through to the next credential source.
+func (s *authFileSource) resolve(host string) (registryCredential, bool, error) {
+	if s == nil || s.path == "" {
+		return registryCredential{}, false, nil
+	}
+
+	auths, err := s.load()
+	if err != nil {
+		return registryCredential{}, false, err
+	}
+
+	entry, ok := auths[host]
+	if !ok {
+		for key, e := range auths {
+			if u, err := url.Parse(key); err == nil && u.Host == host {
+				entry, ok = e, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return registryCredential{}, false, nil
+	}
+
+	if entry.IdentityToken != "" {
+		return registryCredential{Secret: entry.IdentityToken}, true, nil
+	}
+	if entry.Auth == "" {
+		return registryCredential{}, false, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return registryCredential{}, false, fmt.Errorf("decode auth entry for %q in %s: %w", host, s.path, err)
+	}
+	fields := strings.SplitN(string(decoded), ":", 2)
+	if len(fields) != 2 {
+		return registryCredential{}, false, fmt.Errorf("invalid auth entry for %q in %s", host, s.path)
+	}
+	return registryCredential{Username: fields[0], Secret: fields[1]}, true, nil
+}
+
+func (s *authFileSource) load() (map[string]authFileEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("stat auth file %s: %w", s.path, err)
+	}
+	if s.auths != nil && fi.ModTime().Equal(s.modTime) {
+		return s.auths, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("read auth file %s: %w", s.path, err)
+	}
+	var parsed struct {
+		Auths map[string]authFileEntry `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parse auth file %s: %w", s.path, err)
+	}
+
+	s.auths = parsed.Auths
+	s.modTime = fi.ModTime()
+	return s.auths, nil
+}
+
+// ParseAuth parses AuthConfig and dockercfg.
+func ParseAuth(auth *runtime.AuthConfig, host string) (string, string, error) {
+	if auth == nil {
+		return "", "", nil
+	}
+	if auth.ServerAddress != "" {
+		// Do not return the auth info when server address doesn't match.
+		u, err := url.Parse(auth.ServerAddress)
+		if err != nil {
+			return "", "", fmt.Errorf("parse server address: %w", err)
+		}
+		if host != u.Host {
+			return "", "", nil
+		}
+	}
+	if auth.IdentityToken != "" {
+		return "", auth.IdentityToken, nil
+	}
+	if auth.Username != "" {
+		return auth.Username, auth.Password, nil
+	}
+	if auth.Auth != "" {
+		decLen := base64.StdEncoding.DecodedLen(len(auth.Auth))
+		decoded := make([]byte, decLen)
+		_, err := base64.StdEncoding.Decode(decoded, []byte(auth.Auth))
+		if err != nil {
+			return "", "", err
+		}
+		fields := strings.SplitN(string(decoded), ":", 2)
+		if len(fields) != 2 {
+			return "", "", errors.New("invalid decoded auth")
+		}
+		user, passwd := fields[0], fields[1]
+		return user, strings.Trim(passwd, "\x00"), nil
+	}
+	// TODO(random-liu): Support RegistryToken.
+	return "", "", nil
+}
+
+// registryEndpoints returns endpoints for a given host, normalizing
+// configured mirror entries (adding a scheme when one is missing) and
+// appending the registry's own default endpoint when it isn't already
+// present in the mirror list.
+func (c *criService) registryEndpoints(host string) ([]string, error) {
+	var mirrors []string
+	if m, ok := c.config.Registry.Mirrors[host]; ok {
+		mirrors = m.Endpoints
+	} else {
+		mirrors = c.config.Registry.Mirrors["*"].Endpoints
+	}
+
+	defaultHost := defaultScheme(host) + "://" + host
+
+	endpoints := make([]string, 0, len(mirrors)+1)
+	haveDefault := false
+	for _, endpoint := range mirrors {
+		normalized, err := addDefaultScheme(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if sameRegistryHost(normalized, defaultHost) {
+			haveDefault = true
+		}
+		endpoints = append(endpoints, normalized)
+	}
+	if !haveDefault {
+		endpoints = append(endpoints, defaultHost)
+	}
+	return endpoints, nil
+}
+
+// addDefaultScheme prefixes endpoint with its default scheme when it doesn't
+// already specify one.
+func addDefaultScheme(endpoint string) (string, error) {
+	if strings.Contains(endpoint, "://") {
+		return endpoint, nil
+	}
+	return defaultScheme(endpoint) + "://" + endpoint, nil
+}
+
+// sameRegistryHost reports whether a and b share the same scheme and host,
+// ignoring any path suffix.
+func sameRegistryHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && ua.Host == ub.Host
+}
+
+// defaultScheme returns the default scheme for a registry host.
+func defaultScheme(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		h = host
+	}
+	if h == "localhost" || h == "127.0.0.1" || h == "::1" {
+		return "http"
+	}
+	return "https"
+}
+
+// encryptedImagesPullOpts returns the necessary unpack options to decrypt an
+// encrypted image, according to the CRI image decryption configuration.
+func (c *criService) encryptedImagesPullOpts() []containerd.RemoteOpt {
+	if c.config.ImageDecryption.KeyModel == criconfig.KeyModelNode {
+		return []containerd.RemoteOpt{containerd.WithUnpackOpts(nil)}
+	}
+	return nil
+}
+
+// snapshotterFromPodSandboxConfig tries to parse the runtime handler from the
+// PodSandboxConfig and return the configured snapshotter for that runtime,
+// falling back to the default snapshotter if not set.
+func (c *criService) snapshotterFromPodSandboxConfig(ctx context.Context, imageName string, s *runtime.PodSandboxConfig) (string, error) {
+	snapshotter := c.config.ContainerdConfig.Snapshotter
+	if s == nil || s.GetAnnotations() == nil {
+		return snapshotter, nil
+	}
+
+	runtimeHandler, ok := s.GetAnnotations()[annotations.RuntimeHandler]
+	if !ok {
+		return snapshotter, nil
+	}
+
+	ociRuntime, ok := c.config.ContainerdConfig.Runtimes[runtimeHandler]
+	if !ok {
+		return "", fmt.Errorf("no runtime configured for %q", runtimeHandler)
+	}
+	if ociRuntime.Snapshotter != "" {
+		snapshotter = ociRuntime.Snapshotter
+	}
+
+	log.G(ctx).Debugf("Set snapshotter for image %q from config %q", imageName, snapshotter)
+	return snapshotter, nil
+}
+
+// getLabels adds the image managed and (when applicable) pinned sandbox
+// image labels for a pulled image name.
+func (c *criService) getLabels(ctx context.Context, name string) map[string]string {
+	imageLabels := map[string]string{
+		labels.ImageLabelKey: labels.ImageLabelValue,
+	}
+	if c.isSandboxImage(ctx, name) {
+		imageLabels[labels.PinnedImageLabelKey] = labels.PinnedImageLabelValue
+	}
+	return imageLabels
+}
+
+// isSandboxImage reports whether name refers to the same image as the
+// configured sandbox image, comparing canonicalized RepositoryInfo rather
+// than raw strings so that e.g. an implied "docker.io/library/" prefix or a
+// missing ":latest" tag doesn't cause a false negative.
+func (c *criService) isSandboxImage(ctx context.Context, name string) bool {
+	if c.config.SandboxImage == "" {
+		return false
+	}
+
+	want, err := criref.Parse(c.config.SandboxImage)
+	if err != nil {
+		log.G(ctx).WithError(err).Debugf("failed to parse configured sandbox image %q", c.config.SandboxImage)
+		return false
+	}
+	got, err := criref.Parse(name)
+	if err != nil {
+		log.G(ctx).WithError(err).Debugf("failed to parse image reference %q", name)
+		return false
+	}
+	return want.Matches(got)
+}
+
+// localResolve resolves a user specified image reference, which may be a
+// tag, a digest, or an image ID, to an image already present in the local
+// image store.
+func (c *criService) localResolve(refOrID string) (imagestore.Image, error) {
+	if id, err := c.imageStore.Resolve(refOrID); err == nil {
+		return c.imageStore.Get(id)
+	}
+
+	// refOrID didn't resolve as-is. It may still canonicalize to a
+	// reference already tracked under an equivalent but differently
+	// formatted name (e.g. an implied "docker.io/library/" prefix, or a tag
+	// vs. digest mismatch) — compare it against every known reference using
+	// the same RepositoryInfo normalization as getLabels.
+	want, err := criref.Parse(refOrID)
+	if err != nil {
+		return imagestore.Image{}, fmt.Errorf("failed to parse image reference %q: %w", refOrID, err)
+	}
+	for _, img := range c.imageStore.List() {
+		for _, ref := range img.References {
+			got, err := criref.Parse(ref)
+			if err != nil {
+				continue
+			}
+			if want.Matches(got) {
+				return img, nil
+			}
+		}
+	}
+
+	return imagestore.Image{}, fmt.Errorf("no such image %q: %w", refOrID, errdefs.ErrNotFound)
+}
@@ -19,11 +19,16 @@ package server
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/containerd/containerd/pkg/cri/annotations"
 	criconfig "github.com/containerd/containerd/pkg/cri/config"
 	"github.com/containerd/containerd/pkg/cri/labels"
+	imagestore "github.com/containerd/containerd/pkg/cri/store/image"
 
 	"github.com/stretchr/testify/assert"
 	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -473,6 +478,24 @@ func TestImageGetLabels(t *testing.T) {
 			configSandboxImage: "registry.k8s.io/pause:3.9",
 			pullImageName:      "registry.k8s.io/random:latest",
 		},
+		{
+			name:               "pinned image labels should get added when sandbox image has an implied docker.io/library/ prefix",
+			expectedLabel:      map[string]string{labels.ImageLabelKey: labels.ImageLabelValue, labels.PinnedImageLabelKey: labels.PinnedImageLabelValue},
+			configSandboxImage: "pause",
+			pullImageName:      "docker.io/library/pause:latest",
+		},
+		{
+			name:               "pinned image labels should get added when the pulled image uses the legacy index.docker.io domain",
+			expectedLabel:      map[string]string{labels.ImageLabelKey: labels.ImageLabelValue, labels.PinnedImageLabelKey: labels.PinnedImageLabelValue},
+			configSandboxImage: "docker.io/pause:3.9",
+			pullImageName:      "index.docker.io/library/pause:3.9",
+		},
+		{
+			name:               "pinned image labels should not get added when only the repository name happens to match under a different domain",
+			expectedLabel:      map[string]string{labels.ImageLabelKey: labels.ImageLabelValue},
+			configSandboxImage: "pause",
+			pullImageName:      "registry.k8s.io/pause:latest",
+		},
 	}
 
 	svc := newTestCRIService()
@@ -484,3 +507,412 @@ func TestImageGetLabels(t *testing.T) {
 		})
 	}
 }
+
+// TestImageStatusLocalResolve covers localResolve's direct lookup and its
+// canonicalization fallback through ImageStatus's actual resolution path,
+// exercising the same implied "docker.io/library" case TestImageGetLabels
+// covers for getLabels.
+func TestImageStatusLocalResolve(t *testing.T) {
+	const testDigest = "sha256:ee9ca9f0e1d89cf47426afb211b4df85b6e9e1de5757861da2fa9bbd49d7fdb2"
+
+	store, err := imagestore.NewFakeStore([]imagestore.Image{
+		{
+			ID:      "id-by-tag",
+			ChainID: "chain-by-tag",
+			References: []string{
+				"registry.example.com/test/image:latest",
+			},
+		},
+		{
+			ID:      "id-by-digest",
+			ChainID: "chain-by-digest",
+			References: []string{
+				"registry.example.com/test/image@" + testDigest,
+			},
+		},
+		{
+			ID:      "id-library-alias",
+			ChainID: "chain-library-alias",
+			References: []string{
+				"docker.io/library/pause:latest",
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	svc := newTestCRIService()
+	svc.imageStore = store
+
+	for _, test := range []struct {
+		desc        string
+		image       string
+		expectID    string
+		expectFound bool
+	}{
+		{
+			desc:        "exact match resolves directly",
+			image:       "registry.example.com/test/image:latest",
+			expectID:    "id-by-tag",
+			expectFound: true,
+		},
+		{
+			desc:        "digest reference resolves directly",
+			image:       "registry.example.com/test/image@" + testDigest,
+			expectID:    "id-by-digest",
+			expectFound: true,
+		},
+		{
+			desc:        "implied docker.io/library alias matches the bare name",
+			image:       "pause:latest",
+			expectID:    "id-library-alias",
+			expectFound: true,
+		},
+		{
+			desc:        "unknown image is not found",
+			image:       "registry.example.com/test/other:latest",
+			expectFound: false,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := svc.ImageStatus(context.Background(), &runtime.ImageStatusRequest{
+				Image: &runtime.ImageSpec{Image: test.image},
+			})
+			assert.NoError(t, err)
+			if test.expectFound {
+				assert.NotNil(t, resp.GetImage())
+				assert.Equal(t, test.expectID, resp.GetImage().GetId())
+			} else {
+				assert.Nil(t, resp.GetImage())
+			}
+		})
+	}
+}
+
+// writeFakeCredentialHelper writes an executable script to dir that answers
+// `get` with the given username/secret and counts its own invocations in
+// invocations, so tests can assert whether the cache avoided re-execing it.
+func writeFakeCredentialHelper(t *testing.T, dir, name, username, secret string) string {
+	t.Helper()
+	path := filepath.Join(dir, "docker-credential-"+name)
+	invocations := path + ".count"
+	script := fmt.Sprintf(`#!/bin/sh
+echo -n x >> %q
+cat <<JSON
+{"ServerURL":"","Username":%q,"Secret":%q}
+JSON
+`, invocations, username, secret)
+	require := assert.New(t)
+	require.NoError(os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+func countFile(t *testing.T, helperPath string) int {
+	t.Helper()
+	data, err := os.ReadFile(helperPath + ".count")
+	if os.IsNotExist(err) {
+		return 0
+	}
+	assert.NoError(t, err)
+	return len(data)
+}
+
+func TestCredentialHelperSourceResolve(t *testing.T) {
+	dir := t.TempDir()
+	helper := writeFakeCredentialHelper(t, dir, "fake", "helperuser", "helpersecret")
+
+	t.Run("cache hit avoids re-execing the helper", func(t *testing.T) {
+		now := time.Now()
+		s := newCredentialHelperSource(map[string]criconfig.AuthConfig{
+			"registry.example.com": {Helper: helper},
+		}, time.Minute)
+		s.now = func() time.Time { return now }
+
+		cred, ok, err := s.resolve(context.Background(), "registry.example.com")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, registryCredential{Username: "helperuser", Secret: "helpersecret"}, cred)
+		assert.Equal(t, 1, countFile(t, helper))
+
+		cred, ok, err = s.resolve(context.Background(), "registry.example.com")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, registryCredential{Username: "helperuser", Secret: "helpersecret"}, cred)
+		assert.Equal(t, 1, countFile(t, helper), "second resolve within the TTL should hit the cache")
+	})
+
+	t.Run("expired cache entry re-execs the helper", func(t *testing.T) {
+		// A helper of its own, so the invocation count starts at zero
+		// rather than carrying over from the previous subtest.
+		helper := writeFakeCredentialHelper(t, t.TempDir(), "fake", "helperuser", "helpersecret")
+		now := time.Now()
+		s := newCredentialHelperSource(map[string]criconfig.AuthConfig{
+			"registry.example.com": {Helper: helper},
+		}, time.Minute)
+		s.now = func() time.Time { return now }
+
+		_, _, err := s.resolve(context.Background(), "registry.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, countFile(t, helper))
+
+		now = now.Add(2 * time.Minute)
+		_, _, err = s.resolve(context.Background(), "registry.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, countFile(t, helper), "resolve after the TTL expires should re-exec the helper")
+	})
+
+	t.Run("falls back to the wildcard entry", func(t *testing.T) {
+		s := newCredentialHelperSource(map[string]criconfig.AuthConfig{
+			"*": {Helper: helper},
+		}, time.Minute)
+
+		cred, ok, err := s.resolve(context.Background(), "unlisted.example.com")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, registryCredential{Username: "helperuser", Secret: "helpersecret"}, cred)
+	})
+
+	t.Run("no entry for host or wildcard", func(t *testing.T) {
+		s := newCredentialHelperSource(map[string]criconfig.AuthConfig{
+			"other.example.com": {Helper: helper},
+		}, time.Minute)
+
+		_, ok, err := s.resolve(context.Background(), "unlisted.example.com")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("nil source", func(t *testing.T) {
+		var s *credentialHelperSource
+		_, ok, err := s.resolve(context.Background(), "registry.example.com")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestAuthFileSourceResolve(t *testing.T) {
+	t.Run("no config file set", func(t *testing.T) {
+		s := newAuthFileSource("")
+		_, ok, err := s.resolve("registry.example.com")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		s := newAuthFileSource(filepath.Join(t.TempDir(), "does-not-exist.json"))
+		_, _, err := s.resolve("registry.example.com")
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "auth.json")
+		assert.NoError(t, os.WriteFile(path, []byte("{not valid json"), 0o644))
+		s := newAuthFileSource(path)
+		_, _, err := s.resolve("registry.example.com")
+		assert.Error(t, err)
+	})
+
+	t.Run("auth field decodes to username/secret", func(t *testing.T) {
+		auth := base64.StdEncoding.EncodeToString([]byte("fileuser:filesecret"))
+		path := filepath.Join(t.TempDir(), "auth.json")
+		assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q}}}`, auth)), 0o644))
+
+		s := newAuthFileSource(path)
+		cred, ok, err := s.resolve("registry.example.com")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, registryCredential{Username: "fileuser", Secret: "filesecret"}, cred)
+	})
+
+	t.Run("identitytoken takes precedence over auth", func(t *testing.T) {
+		auth := base64.StdEncoding.EncodeToString([]byte("fileuser:filesecret"))
+		path := filepath.Join(t.TempDir(), "auth.json")
+		assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q,"identitytoken":"tok"}}}`, auth)), 0o644))
+
+		s := newAuthFileSource(path)
+		cred, ok, err := s.resolve("registry.example.com")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, registryCredential{Secret: "tok"}, cred)
+	})
+
+	t.Run("no entry for host", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "auth.json")
+		assert.NoError(t, os.WriteFile(path, []byte(`{"auths":{}}`), 0o644))
+
+		s := newAuthFileSource(path)
+		_, ok, err := s.resolve("registry.example.com")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("reloads after mtime changes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "auth.json")
+		authA := base64.StdEncoding.EncodeToString([]byte("usera:seca"))
+		assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q}}}`, authA)), 0o644))
+
+		s := newAuthFileSource(path)
+		cred, _, err := s.resolve("registry.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "usera", cred.Username)
+
+		// Nudge the mtime forward so the reload is observed even on
+		// filesystems with coarse mtime resolution.
+		future := time.Now().Add(time.Second)
+		authB := base64.StdEncoding.EncodeToString([]byte("userb:secb"))
+		assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q}}}`, authB)), 0o644))
+		assert.NoError(t, os.Chtimes(path, future, future))
+
+		cred, _, err = s.resolve("registry.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "userb", cred.Username)
+	})
+}
+
+func TestConfigAuth(t *testing.T) {
+	encodedAuth := base64.StdEncoding.EncodeToString([]byte("configuser:configsecret"))
+
+	for _, test := range []struct {
+		desc         string
+		auth         *criconfig.AuthConfig
+		expectedOK   bool
+		expectedCred registryCredential
+		expectErr    bool
+	}{
+		{
+			desc:       "no Configs entry for host",
+			expectedOK: false,
+		},
+		{
+			desc:       "Configs entry with no Auth set",
+			auth:       nil,
+			expectedOK: false,
+		},
+		{
+			desc:         "identitytoken",
+			auth:         &criconfig.AuthConfig{IdentityToken: "tok"},
+			expectedOK:   true,
+			expectedCred: registryCredential{Secret: "tok"},
+		},
+		{
+			desc:         "username/password",
+			auth:         &criconfig.AuthConfig{Username: "configuser", Password: "configsecret"},
+			expectedOK:   true,
+			expectedCred: registryCredential{Username: "configuser", Secret: "configsecret"},
+		},
+		{
+			desc:         "base64 auth",
+			auth:         &criconfig.AuthConfig{Auth: encodedAuth},
+			expectedOK:   true,
+			expectedCred: registryCredential{Username: "configuser", Secret: "configsecret"},
+		},
+		{
+			desc:      "invalid base64 auth",
+			auth:      &criconfig.AuthConfig{Auth: "not-valid-base64!!"},
+			expectErr: true,
+		},
+		{
+			desc:       "empty AuthConfig has no credential",
+			auth:       &criconfig.AuthConfig{},
+			expectedOK: false,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			svc := newTestCRIService()
+			if test.desc != "no Configs entry for host" {
+				svc.config.Registry.Configs = map[string]criconfig.RegistryConfig{
+					"registry.example.com": {Auth: test.auth},
+				}
+			}
+
+			cred, ok, err := svc.configAuth(context.Background(), "registry.example.com")
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedOK, ok)
+			if test.expectedOK {
+				assert.Equal(t, test.expectedCred, cred)
+			}
+		})
+	}
+}
+
+func TestCredentialsForHostFallsBackToConfigAuth(t *testing.T) {
+	svc := newTestCRIService()
+	svc.credHelpers = newCredentialHelperSource(nil, time.Minute)
+	svc.authFile = newAuthFileSource("")
+	svc.config.Registry.Configs = map[string]criconfig.RegistryConfig{
+		"registry.example.com": {Auth: &criconfig.AuthConfig{Username: "configuser", Password: "configsecret"}},
+	}
+
+	user, secret, err := svc.credentialsForHost(context.Background(), "registry.example.com", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "configuser", user)
+	assert.Equal(t, "configsecret", secret)
+}
+
+func TestCredentialsForHostAuthSoftFail(t *testing.T) {
+	svc := newTestCRIService()
+	// Configured for a different host than the one under test, so
+	// hasCredentialSource is true but resolve cleanly reports no match for
+	// "registry.example.com" instead of execing a nonexistent helper.
+	svc.credHelpers = newCredentialHelperSource(map[string]criconfig.AuthConfig{
+		"other.example.com": {Helper: "unused"},
+	}, time.Minute)
+	svc.authFile = newAuthFileSource("")
+
+	for _, test := range []struct {
+		desc            string
+		globalSoftFail  bool
+		annotationValue string
+		hasAnnotation   bool
+		expectErr       bool
+	}{
+		{
+			desc:           "global soft fail disabled fails the pull",
+			globalSoftFail: false,
+			expectErr:      true,
+		},
+		{
+			desc:           "global soft fail enabled falls back to anonymous",
+			globalSoftFail: true,
+			expectErr:      false,
+		},
+		{
+			desc:            "per-pod annotation overrides global disabled",
+			globalSoftFail:  false,
+			hasAnnotation:   true,
+			annotationValue: "true",
+			expectErr:       false,
+		},
+		{
+			desc:            "per-pod annotation overrides global enabled",
+			globalSoftFail:  true,
+			hasAnnotation:   true,
+			annotationValue: "false",
+			expectErr:       true,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			svc.config.Registry.AuthSoftFail = test.globalSoftFail
+
+			var sandbox *runtime.PodSandboxConfig
+			if test.hasAnnotation {
+				sandbox = &runtime.PodSandboxConfig{
+					Annotations: map[string]string{authSoftFailAnnotation: test.annotationValue},
+				}
+			}
+
+			_, _, err := svc.credentialsForHost(context.Background(), "registry.example.com", nil, sandbox)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
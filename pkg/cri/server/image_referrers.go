@@ -0,0 +1,177 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	imagestore "github.com/containerd/containerd/pkg/cri/store/image"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+	godigest "github.com/opencontainers/go-digest"
+
+	distref "github.com/distribution/reference"
+)
+
+const ociImageIndexMediaType = "application/vnd.oci.image.index.v1+json"
+
+// referrerInfo is one entry of an image's OCI 1.1 referrers, as surfaced
+// through verboseImageInfo.
+type referrerInfo struct {
+	Digest       string `json:"digest"`
+	ArtifactType string `json:"artifactType"`
+	MediaType    string `json:"mediaType"`
+}
+
+// imageReferrers lists image's OCI 1.1 referrers by querying the registry's
+// /v2/<name>/referrers/<digest> API.
+//
+// There is no local content-store indexing here: nothing in containerd's
+// ingest path records which blobs are referrer manifests or what subject
+// they point at, so there is no cheap local source of truth to consult
+// first. Every verbose ImageStatus call therefore costs one registry round
+// trip; an offline or unreachable registry is reported as "no referrers"
+// rather than as an error, since referrers are advisory information.
+func (c *criService) imageReferrers(ctx context.Context, image *imagestore.Image) ([]referrerInfo, error) {
+	subject, err := godigest.Parse(image.ID)
+	if err != nil {
+		return nil, fmt.Errorf("image id %q is not a digest: %w", image.ID, err)
+	}
+
+	referrers, err := c.remoteReferrers(ctx, image, subject)
+	if err != nil {
+		log.G(ctx).WithError(err).Debugf("referrers API unavailable for %s, reporting no referrers", subject)
+		return nil, nil
+	}
+	return referrers, nil
+}
+
+// remoteReferrers queries the registry's OCI 1.1 referrers API
+// (/v2/<name>/referrers/<digest>) for image's manifest, going through
+// c.registryHosts so mirrors, per-host TLS config, and node-configured
+// credentials match whatever PullImage would use for the same host; no
+// kubelet AuthConfig or per-pod annotation is applied. Network and protocol
+// errors are returned to the caller, which treats referrers as advisory and
+// falls back to reporting none.
+func (c *criService) remoteReferrers(ctx context.Context, image *imagestore.Image, subject godigest.Digest) ([]referrerInfo, error) {
+	if len(image.References) == 0 {
+		return nil, nil
+	}
+	ref := image.References[0]
+
+	host, name, err := hostAndPathFromImageRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := c.registryHosts(ctx, nil, nil)(host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve registry hosts for %q: %w", host, err)
+	}
+
+	var lastErr error
+	for _, h := range hosts {
+		referrers, err := fetchReferrers(ctx, h, name, subject)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return referrers, nil
+	}
+	return nil, lastErr
+}
+
+// fetchReferrers issues the referrers request against a single resolved
+// docker.RegistryHost, reusing its client and authorizer. A 401 challenge is
+// answered through the authorizer and the request retried once.
+func fetchReferrers(ctx context.Context, h docker.RegistryHost, name string, subject godigest.Digest) ([]referrerInfo, error) {
+	endpoint := fmt.Sprintf("%s://%s%s/%s/referrers/%s", h.Scheme, h.Host, h.Path, name, subject)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build referrers request for %s: %w", endpoint, err)
+	}
+	req.Header.Set("Accept", ociImageIndexMediaType)
+
+	if h.Authorizer != nil {
+		if err := h.Authorizer.Authorize(ctx, req); err != nil {
+			return nil, fmt.Errorf("authorize referrers request for %s: %w", endpoint, err)
+		}
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query referrers endpoint %s: %w", endpoint, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && h.Authorizer != nil {
+		resp.Body.Close()
+		if err := h.Authorizer.AddResponses(ctx, []*http.Response{resp}); err != nil {
+			return nil, fmt.Errorf("handle auth challenge from %s: %w", endpoint, err)
+		}
+		if err := h.Authorizer.Authorize(ctx, req); err != nil {
+			return nil, fmt.Errorf("authorize referrers request for %s: %w", endpoint, err)
+		}
+		if resp, err = client.Do(req); err != nil {
+			return nil, fmt.Errorf("query referrers endpoint %s: %w", endpoint, err)
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Registries that don't implement the referrers API (or have
+		// nothing to report) answer 404/501; treat that as "no referrers"
+		// rather than an error.
+		return nil, nil
+	}
+
+	var index struct {
+		Manifests []struct {
+			Digest       string `json:"digest"`
+			MediaType    string `json:"mediaType"`
+			ArtifactType string `json:"artifactType"`
+		} `json:"manifests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("decode referrers response from %s: %w", endpoint, err)
+	}
+
+	referrers := make([]referrerInfo, 0, len(index.Manifests))
+	for _, m := range index.Manifests {
+		referrers = append(referrers, referrerInfo{
+			Digest:       m.Digest,
+			ArtifactType: m.ArtifactType,
+			MediaType:    m.MediaType,
+		})
+	}
+	return referrers, nil
+}
+
+// hostAndPathFromImageRef splits ref into the registry host and repository
+// path to use against the OCI distribution API.
+func hostAndPathFromImageRef(ref string) (host, path string, err error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse image reference %q: %w", ref, err)
+	}
+	return distref.Domain(named), distref.Path(named), nil
+}
@@ -0,0 +1,167 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	criconfig "github.com/containerd/containerd/pkg/cri/config"
+	imagestore "github.com/containerd/containerd/pkg/cri/store/image"
+	"github.com/containerd/containerd/remotes/docker"
+	godigest "github.com/opencontainers/go-digest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSubjectDigest = godigest.Digest("sha256:ee9ca9f0e1d89cf47426afb211b4df85b6e9e1de5757861da2fa9bbd49d7fdb2")
+
+func TestHostAndPathFromImageRef(t *testing.T) {
+	for _, test := range []struct {
+		desc         string
+		ref          string
+		expectedHost string
+		expectedPath string
+	}{
+		{
+			desc:         "explicit registry host",
+			ref:          "registry.example.com/test/image@sha256:ee9ca9f0e1d89cf47426afb211b4df85b6e9e1de5757861da2fa9bbd49d7fdb2",
+			expectedHost: "registry.example.com",
+			expectedPath: "test/image",
+		},
+		{
+			desc:         "implied docker.io/library prefix",
+			ref:          "pause:latest",
+			expectedHost: "docker.io",
+			expectedPath: "library/pause",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			host, path, err := hostAndPathFromImageRef(test.ref)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedHost, host)
+			assert.Equal(t, test.expectedPath, path)
+		})
+	}
+
+	t.Run("invalid reference", func(t *testing.T) {
+		_, _, err := hostAndPathFromImageRef("in valid ref")
+		assert.Error(t, err)
+	})
+}
+
+func TestFetchReferrers(t *testing.T) {
+	t.Run("parses the referrers index", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, fmt.Sprintf("/v2/test/image/referrers/%s", testSubjectDigest), r.URL.Path)
+			w.Header().Set("Content-Type", ociImageIndexMediaType)
+			fmt.Fprintf(w, `{"manifests":[{"digest":"sha256:17fce9f2d36b4b5637cf6e3bfa8a6a1d04b39c44bbe39b85a1134fbde328e7d8","mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"application/vnd.example.sbom"}]}`)
+		}))
+		defer srv.Close()
+
+		h := registryHostForTestServer(t, srv)
+		referrers, err := fetchReferrers(context.Background(), h, "test/image", testSubjectDigest)
+		assert.NoError(t, err)
+		assert.Equal(t, []referrerInfo{{
+			Digest:       "sha256:17fce9f2d36b4b5637cf6e3bfa8a6a1d04b39c44bbe39b85a1134fbde328e7d8",
+			ArtifactType: "application/vnd.example.sbom",
+			MediaType:    "application/vnd.oci.image.manifest.v1+json",
+		}}, referrers)
+	})
+
+	t.Run("404 is reported as no referrers, not an error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		h := registryHostForTestServer(t, srv)
+		referrers, err := fetchReferrers(context.Background(), h, "test/image", testSubjectDigest)
+		assert.NoError(t, err)
+		assert.Empty(t, referrers)
+	})
+
+	t.Run("invalid json is an error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "{not valid")
+		}))
+		defer srv.Close()
+
+		h := registryHostForTestServer(t, srv)
+		_, err := fetchReferrers(context.Background(), h, "test/image", testSubjectDigest)
+		assert.Error(t, err)
+	})
+}
+
+func registryHostForTestServer(t *testing.T, srv *httptest.Server) docker.RegistryHost {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+	return docker.RegistryHost{
+		Client: srv.Client(),
+		Host:   u.Host,
+		Scheme: u.Scheme,
+		Path:   "/v2",
+	}
+}
+
+func TestRemoteReferrers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"manifests":[{"digest":"sha256:17fce9f2d36b4b5637cf6e3bfa8a6a1d04b39c44bbe39b85a1134fbde328e7d8","mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"application/vnd.example.sbom"}]}`)
+	}))
+	defer srv.Close()
+
+	svc := newTestCRIService()
+	svc.config.Registry.Mirrors = map[string]criconfig.Mirror{
+		"registry.example.com": {Endpoints: []string{srv.URL}},
+	}
+
+	image := &imagestore.Image{References: []string{"registry.example.com/test/image@" + testSubjectDigest.String()}}
+	referrers, err := svc.remoteReferrers(context.Background(), image, testSubjectDigest)
+	assert.NoError(t, err)
+	assert.Len(t, referrers, 1)
+	assert.Equal(t, "application/vnd.example.sbom", referrers[0].ArtifactType)
+}
+
+func TestImageReferrers(t *testing.T) {
+	t.Run("non-digest image ID is an error", func(t *testing.T) {
+		svc := newTestCRIService()
+		image := &imagestore.Image{ID: "not-a-digest"}
+		_, err := svc.imageReferrers(context.Background(), image)
+		assert.Error(t, err)
+	})
+
+	t.Run("unreachable registry is reported as no referrers, not an error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		svc := newTestCRIService()
+		image := &imagestore.Image{
+			ID:         testSubjectDigest.String(),
+			References: []string{"registry.invalid.example/test/image@" + testSubjectDigest.String()},
+		}
+		referrers, err := svc.imageReferrers(ctx, image)
+		assert.NoError(t, err)
+		assert.Nil(t, referrers)
+	})
+}
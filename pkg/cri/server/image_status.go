@@ -85,6 +85,11 @@ func toCRIImage(image imagestore.Image) *runtime.Image {
 type verboseImageInfo struct {
 	ChainID   string          `json:"chainID"`
 	ImageSpec imagespec.Image `json:"imageSpec"`
+	// Referrers lists the image manifest's OCI 1.1 referrers (SBOMs,
+	// in-toto attestations, cosign signatures), so that node-local policy
+	// agents can read attestation availability through the CRI without a
+	// second registry round-trip.
+	Referrers []referrerInfo `json:"referrers,omitempty"`
 }
 
 // toCRIImageInfo converts internal image object information to CRI image status response info map.
@@ -100,6 +105,13 @@ func (c *criService) toCRIImageInfo(ctx context.Context, image *imagestore.Image
 		ImageSpec: image.ImageSpec,
 	}
 
+	referrers, err := c.imageReferrers(ctx, image)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("failed to list referrers for image %q", image.ID)
+	} else {
+		imi.Referrers = referrers
+	}
+
 	m, err := json.Marshal(imi)
 	if err == nil {
 		info["info"] = string(m)
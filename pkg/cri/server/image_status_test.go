@@ -0,0 +1,83 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	criconfig "github.com/containerd/containerd/pkg/cri/config"
+	imagestore "github.com/containerd/containerd/pkg/cri/store/image"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToCRIImageInfoReferrers(t *testing.T) {
+	t.Run("not verbose returns no info", func(t *testing.T) {
+		svc := newTestCRIService()
+		info, err := svc.toCRIImageInfo(context.Background(), &imagestore.Image{}, false)
+		assert.NoError(t, err)
+		assert.Nil(t, info)
+	})
+
+	t.Run("verbose info includes referrers resolved from the registry", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"manifests":[{"digest":"sha256:17fce9f2d36b4b5637cf6e3bfa8a6a1d04b39c44bbe39b85a1134fbde328e7d8","mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"application/vnd.example.sbom"}]}`)
+		}))
+		defer srv.Close()
+
+		svc := newTestCRIService()
+		svc.config.Registry.Mirrors = map[string]criconfig.Mirror{
+			"registry.example.com": {Endpoints: []string{srv.URL}},
+		}
+
+		image := &imagestore.Image{
+			ID:         testSubjectDigest.String(),
+			ChainID:    "test-chain-id",
+			References: []string{"registry.example.com/test/image@" + testSubjectDigest.String()},
+		}
+
+		info, err := svc.toCRIImageInfo(context.Background(), image, true)
+		assert.NoError(t, err)
+
+		var imi verboseImageInfo
+		assert.NoError(t, json.Unmarshal([]byte(info["info"]), &imi))
+		assert.Equal(t, "test-chain-id", imi.ChainID)
+		assert.Equal(t, []referrerInfo{{
+			Digest:       "sha256:17fce9f2d36b4b5637cf6e3bfa8a6a1d04b39c44bbe39b85a1134fbde328e7d8",
+			ArtifactType: "application/vnd.example.sbom",
+			MediaType:    "application/vnd.oci.image.manifest.v1+json",
+		}}, imi.Referrers)
+	})
+
+	t.Run("referrers lookup failure still returns the rest of the info", func(t *testing.T) {
+		svc := newTestCRIService()
+		image := &imagestore.Image{ID: "not-a-digest", ChainID: "test-chain-id"}
+
+		info, err := svc.toCRIImageInfo(context.Background(), image, true)
+		assert.NoError(t, err)
+
+		var imi verboseImageInfo
+		assert.NoError(t, json.Unmarshal([]byte(info["info"]), &imi))
+		assert.Equal(t, "test-chain-id", imi.ChainID)
+		assert.Empty(t, imi.Referrers)
+	})
+}
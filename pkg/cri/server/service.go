@@ -0,0 +1,76 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"time"
+
+	containerd "github.com/containerd/containerd"
+	criconfig "github.com/containerd/containerd/pkg/cri/config"
+	imagestore "github.com/containerd/containerd/pkg/cri/store/image"
+	"github.com/containerd/log"
+)
+
+// defaultCredentialHelperCacheTTL is used when Registry.CredentialCacheTTL
+// is unset or fails to parse as a Go duration.
+const defaultCredentialHelperCacheTTL = 5 * time.Minute
+
+// criService implements the CRI image, container, and pod sandbox services
+// on top of a containerd client.
+type criService struct {
+	// config is the CRI plugin configuration.
+	config criconfig.Config
+	// client is the containerd client used for pulls, content access, and
+	// snapshot management.
+	client *containerd.Client
+	// imageStore tracks images known to the CRI plugin.
+	imageStore *imagestore.Store
+
+	// credHelpers resolves registry credentials via Registry.Auths'
+	// configured credential helpers.
+	credHelpers *credentialHelperSource
+	// authFile resolves registry credentials from Registry.ConfigFile, a
+	// static auth.json.
+	authFile *authFileSource
+}
+
+// NewCRIService creates a new CRI service, wiring up the registry
+// credential sources declared in config.Registry.
+func NewCRIService(config criconfig.Config, client *containerd.Client, imageStore *imagestore.Store) (*criService, error) {
+	c := &criService{
+		config:      config,
+		client:      client,
+		imageStore:  imageStore,
+		credHelpers: newCredentialHelperSource(config.Registry.Auths, credentialHelperCacheTTL(config.Registry)),
+		authFile:    newAuthFileSource(config.Registry.ConfigFile),
+	}
+	return c, nil
+}
+
+// credentialHelperCacheTTL parses Registry.CredentialCacheTTL, falling back
+// to defaultCredentialHelperCacheTTL when it's unset or invalid.
+func credentialHelperCacheTTL(registry criconfig.Registry) time.Duration {
+	if registry.CredentialCacheTTL == "" {
+		return defaultCredentialHelperCacheTTL
+	}
+	ttl, err := time.ParseDuration(registry.CredentialCacheTTL)
+	if err != nil {
+		log.L.WithError(err).Warnf("invalid registry.credential_cache_ttl %q, using default of %s", registry.CredentialCacheTTL, defaultCredentialHelperCacheTTL)
+		return defaultCredentialHelperCacheTTL
+	}
+	return ttl
+}